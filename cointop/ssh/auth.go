@@ -0,0 +1,156 @@
+//+build !windows
+
+package ssh
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"golang.org/x/crypto/bcrypt"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// checkAuthorizedKey reports whether key matches an entry in
+// s.authorizedKeysFile.
+func (s *Server) checkAuthorizedKey(key ssh.PublicKey) bool {
+	if s.authorizedKeysFile == "" {
+		return false
+	}
+
+	keys, err := loadAuthorizedKeys(s.authorizedKeysFile)
+	if err != nil {
+		return false
+	}
+
+	for _, authorized := range keys {
+		if ssh.KeysEqual(key, authorized) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkCertificate reports whether key is a certificate signed by one of
+// the CAs in s.trustedUserCAKeys, valid for the requested login user and
+// the current time.
+func (s *Server) checkCertificate(ctx ssh.Context, key ssh.PublicKey) bool {
+	if s.trustedUserCAKeys == "" {
+		return false
+	}
+
+	cert, ok := key.(*gossh.Certificate)
+	if !ok || cert.CertType != gossh.UserCert {
+		return false
+	}
+
+	cas, err := loadAuthorizedKeys(s.trustedUserCAKeys)
+	if err != nil {
+		return false
+	}
+
+	trusted := false
+	for _, ca := range cas {
+		if ssh.KeysEqual(cert.SignatureKey, ca) {
+			trusted = true
+			break
+		}
+	}
+
+	if !trusted {
+		return false
+	}
+
+	if !principalAuthorized(cert.ValidPrincipals, ctx.User()) {
+		return false
+	}
+
+	now := uint64(time.Now().Unix())
+	if cert.ValidAfter != 0 && now < cert.ValidAfter {
+		return false
+	}
+
+	if cert.ValidBefore != gossh.CertTimeInfinity && now > cert.ValidBefore {
+		return false
+	}
+
+	checker := &gossh.CertChecker{}
+	if err := checker.CheckCert(ctx.User(), cert); err != nil {
+		return false
+	}
+
+	return true
+}
+
+// principalAuthorized reports whether user is among principals, or
+// principals is empty (meaning the certificate is valid for any
+// principal).
+func principalAuthorized(principals []string, user string) bool {
+	if len(principals) == 0 {
+		return true
+	}
+
+	for _, principal := range principals {
+		if principal == user {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPassword reports whether password matches the bcrypt hash stored
+// for user in s.passwordFile, a file of "username:bcrypt-hash" lines.
+func (s *Server) checkPassword(user, password string) bool {
+	if s.passwordFile == "" {
+		return false
+	}
+
+	f, err := os.Open(s.passwordFile)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] != user {
+			continue
+		}
+
+		return bcrypt.CompareHashAndPassword([]byte(parts[1]), []byte(password)) == nil
+	}
+
+	return false
+}
+
+// loadAuthorizedKeys parses every entry in an authorized_keys-format
+// file at path.
+func loadAuthorizedKeys(path string) ([]gossh.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []gossh.PublicKey
+	for len(data) > 0 {
+		key, _, _, rest, err := gossh.ParseAuthorizedKey(data)
+		if err != nil {
+			break
+		}
+
+		keys = append(keys, key)
+		data = rest
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,214 @@
+//+build !windows
+
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"golang.org/x/crypto/bcrypt"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestPrincipalAuthorized(t *testing.T) {
+	cases := []struct {
+		name       string
+		principals []string
+		user       string
+		want       bool
+	}{
+		{"empty list allows anyone", nil, "alice", true},
+		{"listed principal allowed", []string{"alice", "bob"}, "alice", true},
+		{"unlisted principal denied", []string{"bob"}, "alice", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := principalAuthorized(tc.principals, tc.user); got != tc.want {
+				t.Errorf("principalAuthorized(%v, %q) = %v, want %v", tc.principals, tc.user, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServerCheckPassword(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct-horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "passwords")
+	content := "alice:" + string(hash) + "\n"
+	if err := os.WriteFile(passwordFile, []byte(content), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	s := &Server{passwordFile: passwordFile}
+
+	if !s.checkPassword("alice", "correct-horse") {
+		t.Error("expected correct password to be accepted")
+	}
+
+	if s.checkPassword("alice", "wrong-password") {
+		t.Error("expected wrong password to be rejected")
+	}
+
+	if s.checkPassword("bob", "correct-horse") {
+		t.Error("expected unknown user to be rejected")
+	}
+
+	unset := &Server{}
+	if unset.checkPassword("alice", "correct-horse") {
+		t.Error("expected checkPassword to reject when no password file is configured")
+	}
+}
+
+func TestServerCheckAuthorizedKey(t *testing.T) {
+	pub1, _ := generateTestKey(t)
+	pub2, _ := generateTestKey(t)
+
+	dir := t.TempDir()
+	authorizedKeysFile := filepath.Join(dir, "authorized_keys")
+	content := string(gossh.MarshalAuthorizedKey(pub1))
+	if err := os.WriteFile(authorizedKeysFile, []byte(content), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	s := &Server{authorizedKeysFile: authorizedKeysFile}
+
+	if !s.checkAuthorizedKey(pub1) {
+		t.Error("expected listed key to be accepted")
+	}
+
+	if s.checkAuthorizedKey(pub2) {
+		t.Error("expected unlisted key to be rejected")
+	}
+
+	unset := &Server{}
+	if unset.checkAuthorizedKey(pub1) {
+		t.Error("expected checkAuthorizedKey to reject when no authorized_keys file is configured")
+	}
+}
+
+func TestServerCheckCertificate(t *testing.T) {
+	caPub, caPriv := generateTestKey(t)
+	leafPub, _ := generateTestKey(t)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "trusted_user_ca")
+	content := string(gossh.MarshalAuthorizedKey(caPub))
+	if err := os.WriteFile(caFile, []byte(content), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	s := &Server{trustedUserCAKeys: caFile}
+
+	sign := func(principals []string, validAfter, validBefore uint64) *gossh.Certificate {
+		cert := &gossh.Certificate{
+			Key:             leafPub,
+			CertType:        gossh.UserCert,
+			ValidPrincipals: principals,
+			ValidAfter:      validAfter,
+			ValidBefore:     validBefore,
+		}
+
+		if err := cert.SignCert(rand.Reader, caPriv); err != nil {
+			t.Fatalf("SignCert: %v", err)
+		}
+
+		return cert
+	}
+
+	now := uint64(time.Now().Unix())
+
+	cases := []struct {
+		name string
+		cert *gossh.Certificate
+		user string
+		want bool
+	}{
+		{"valid cert for principal", sign([]string{"alice"}, 0, gossh.CertTimeInfinity), "alice", true},
+		{"valid cert, any principal", sign(nil, 0, gossh.CertTimeInfinity), "alice", true},
+		{"wrong principal", sign([]string{"bob"}, 0, gossh.CertTimeInfinity), "alice", false},
+		{"expired cert", sign([]string{"alice"}, 0, now-3600), "alice", false},
+		{"not yet valid", sign([]string{"alice"}, now+3600, gossh.CertTimeInfinity), "alice", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &fakeContext{Context: context.Background(), user: tc.user}
+			if got := s.checkCertificate(ctx, tc.cert); got != tc.want {
+				t.Errorf("checkCertificate() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	// A cert signed by an untrusted CA must be rejected.
+	untrustedPub, untrustedPriv := generateTestKey(t)
+	_ = untrustedPub
+	cert := &gossh.Certificate{
+		Key:             leafPub,
+		CertType:        gossh.UserCert,
+		ValidPrincipals: []string{"alice"},
+		ValidBefore:     gossh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, untrustedPriv); err != nil {
+		t.Fatalf("SignCert: %v", err)
+	}
+
+	ctx := &fakeContext{Context: context.Background(), user: "alice"}
+	if s.checkCertificate(ctx, cert) {
+		t.Error("expected cert signed by an untrusted CA to be rejected")
+	}
+}
+
+// generateTestKey returns a fresh ed25519 gossh.PublicKey/Signer pair.
+func generateTestKey(t *testing.T) (gossh.PublicKey, gossh.Signer) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey: %v", err)
+	}
+
+	sshPub, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("NewPublicKey: %v", err)
+	}
+
+	return sshPub, signer
+}
+
+// fakeContext is a minimal ssh.Context for exercising checkCertificate
+// without a real SSH handshake.
+type fakeContext struct {
+	context.Context
+	sync.Mutex
+	user string
+}
+
+func (c *fakeContext) User() string                    { return c.user }
+func (c *fakeContext) SetUser(user string)             { c.user = user }
+func (c *fakeContext) SessionID() string               { return "test-session" }
+func (c *fakeContext) ClientVersion() string           { return "SSH-2.0-test-client" }
+func (c *fakeContext) ServerVersion() string           { return "SSH-2.0-test-server" }
+func (c *fakeContext) RemoteAddr() net.Addr            { return &net.TCPAddr{} }
+func (c *fakeContext) LocalAddr() net.Addr             { return &net.TCPAddr{} }
+func (c *fakeContext) Permissions() *ssh.Permissions   { return &ssh.Permissions{} }
+func (c *fakeContext) SetValue(key, value interface{}) {}
+
+var _ ssh.Context = (*fakeContext)(nil)
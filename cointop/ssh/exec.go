@@ -0,0 +1,217 @@
+//+build !windows
+
+package ssh
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// CoinDataSource is the subset of the cointop core APIs the exec
+// subsystem dispatches to. It lets the SSH server stay decoupled from
+// the concrete cointop implementation it's wired up with.
+type CoinDataSource interface {
+	Prices(symbols []string) ([]PriceQuote, error)
+	Portfolio(fingerprint string) (PortfolioSnapshot, error)
+	Holdings(fingerprint string) ([]Holding, error)
+	Chart(symbol, rangeStr string) (ChartSeries, error)
+}
+
+// PriceQuote is a single coin's latest price data.
+type PriceQuote struct {
+	Symbol    string  `json:"symbol"`
+	Name      string  `json:"name"`
+	Price     float64 `json:"price"`
+	Change1h  float64 `json:"change_1h"`
+	Change24h float64 `json:"change_24h"`
+}
+
+// Holding is a single position in the user's portfolio.
+type Holding struct {
+	Symbol   string  `json:"symbol"`
+	Quantity float64 `json:"quantity"`
+	Value    float64 `json:"value"`
+}
+
+// PortfolioSnapshot is the user's full portfolio at a point in time.
+type PortfolioSnapshot struct {
+	TotalValue float64   `json:"total_value"`
+	Holdings   []Holding `json:"holdings"`
+}
+
+// ChartSeries is a price history for a single coin.
+type ChartSeries struct {
+	Symbol string       `json:"symbol"`
+	Range  string       `json:"range"`
+	Points []ChartPoint `json:"points"`
+}
+
+// ChartPoint is a single timestamped sample in a ChartSeries.
+type ChartPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Price     float64 `json:"price"`
+}
+
+// handleExec services a non-PTY session by parsing its requested
+// command and dispatching to the matching cointop core API, writing
+// machine-readable output to stdout, e.g.
+// `ssh host cointop prices BTC,ETH --format=json`.
+func (s *Server) handleExec(sshSession ssh.Session) {
+	if s.dataSource == nil {
+		io.WriteString(sshSession, "Error: this server does not support exec commands\n")
+		sshSession.Exit(1)
+		return
+	}
+
+	fingerprint, _ := sshSession.Context().Value(fingerprintContextKey).(string)
+
+	args := sshSession.Command()
+	if len(args) < 2 || args[0] != "cointop" {
+		io.WriteString(sshSession, "Error: usage: cointop <prices|portfolio|holdings|chart> [args] [--format=json|csv]\n")
+		sshSession.Exit(1)
+		return
+	}
+
+	subcommand := args[1]
+	format := "json"
+	var rest []string
+	for _, arg := range args[2:] {
+		if strings.HasPrefix(arg, "--format=") {
+			format = strings.TrimPrefix(arg, "--format=")
+			continue
+		}
+
+		rest = append(rest, arg)
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+
+	switch subcommand {
+	case "prices":
+		var symbols []string
+		if len(rest) > 0 {
+			symbols = strings.Split(rest[0], ",")
+		}
+
+		result, err = s.dataSource.Prices(symbols)
+	case "portfolio":
+		if fingerprint == "" {
+			io.WriteString(sshSession, "Error: portfolio data requires public-key authentication\n")
+			sshSession.Exit(1)
+			return
+		}
+
+		result, err = s.dataSource.Portfolio(fingerprint)
+	case "holdings":
+		if fingerprint == "" {
+			io.WriteString(sshSession, "Error: holdings data requires public-key authentication\n")
+			sshSession.Exit(1)
+			return
+		}
+
+		result, err = s.dataSource.Holdings(fingerprint)
+	case "chart":
+		if len(rest) < 1 {
+			io.WriteString(sshSession, "Error: usage: cointop chart <symbol> [range]\n")
+			sshSession.Exit(1)
+			return
+		}
+
+		rangeStr := "24h"
+		if len(rest) > 1 {
+			rangeStr = rest[1]
+		}
+
+		result, err = s.dataSource.Chart(rest[0], rangeStr)
+	default:
+		fmt.Fprintf(sshSession, "Error: unknown subcommand %q\n", subcommand)
+		sshSession.Exit(1)
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintf(sshSession, "Error: %s\n", err)
+		sshSession.Exit(1)
+		return
+	}
+
+	if err := writeResult(sshSession, format, result); err != nil {
+		fmt.Fprintf(sshSession, "Error: %s\n", err)
+		sshSession.Exit(1)
+		return
+	}
+
+	sshSession.Exit(0)
+}
+
+// writeResult marshals result to w in the requested format.
+func writeResult(w io.Writer, format string, result interface{}) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		return enc.Encode(result)
+	case "csv":
+		return writeCSV(w, result)
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// writeCSV renders the exec subsystem's result types as CSV, since they
+// don't share a common tabular shape.
+func writeCSV(w io.Writer, result interface{}) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	switch v := result.(type) {
+	case []PriceQuote:
+		cw.Write([]string{"symbol", "name", "price", "change_1h", "change_24h"})
+		for _, q := range v {
+			cw.Write([]string{
+				q.Symbol, q.Name,
+				strconv.FormatFloat(q.Price, 'f', -1, 64),
+				strconv.FormatFloat(q.Change1h, 'f', -1, 64),
+				strconv.FormatFloat(q.Change24h, 'f', -1, 64),
+			})
+		}
+	case PortfolioSnapshot:
+		cw.Write([]string{"symbol", "quantity", "value"})
+		for _, h := range v.Holdings {
+			cw.Write([]string{
+				h.Symbol,
+				strconv.FormatFloat(h.Quantity, 'f', -1, 64),
+				strconv.FormatFloat(h.Value, 'f', -1, 64),
+			})
+		}
+	case []Holding:
+		cw.Write([]string{"symbol", "quantity", "value"})
+		for _, h := range v {
+			cw.Write([]string{
+				h.Symbol,
+				strconv.FormatFloat(h.Quantity, 'f', -1, 64),
+				strconv.FormatFloat(h.Value, 'f', -1, 64),
+			})
+		}
+	case ChartSeries:
+		cw.Write([]string{"timestamp", "price"})
+		for _, p := range v.Points {
+			cw.Write([]string{
+				strconv.FormatInt(p.Timestamp, 10),
+				strconv.FormatFloat(p.Price, 'f', -1, 64),
+			})
+		}
+	default:
+		return fmt.Errorf("csv format not supported for this result")
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+//+build !windows
+
+package ssh
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteCSV(t *testing.T) {
+	cases := []struct {
+		name   string
+		result interface{}
+		want   []string
+	}{
+		{
+			"price quotes",
+			[]PriceQuote{{Symbol: "BTC", Name: "Bitcoin", Price: 50000, Change1h: 0.5, Change24h: -1.5}},
+			[]string{"symbol,name,price,change_1h,change_24h", "BTC,Bitcoin,50000,0.5,-1.5"},
+		},
+		{
+			"portfolio snapshot",
+			PortfolioSnapshot{Holdings: []Holding{{Symbol: "ETH", Quantity: 2, Value: 4000}}},
+			[]string{"symbol,quantity,value", "ETH,2,4000"},
+		},
+		{
+			"holdings",
+			[]Holding{{Symbol: "ETH", Quantity: 2, Value: 4000}},
+			[]string{"symbol,quantity,value", "ETH,2,4000"},
+		},
+		{
+			"chart series",
+			ChartSeries{Symbol: "BTC", Range: "24h", Points: []ChartPoint{{Timestamp: 1000, Price: 50000}}},
+			[]string{"timestamp,price", "1000,50000"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeCSV(&buf, tc.result); err != nil {
+				t.Fatalf("writeCSV: %v", err)
+			}
+
+			got := strings.TrimRight(buf.String(), "\r\n")
+			lines := strings.Split(got, "\n")
+			for i, line := range lines {
+				lines[i] = strings.TrimRight(line, "\r")
+			}
+
+			if len(lines) != len(tc.want) {
+				t.Fatalf("writeCSV output = %q, want lines %v", buf.String(), tc.want)
+			}
+
+			for i, want := range tc.want {
+				if lines[i] != want {
+					t.Errorf("line %d = %q, want %q", i, lines[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteCSVUnsupportedType(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, "not a supported result type"); err == nil {
+		t.Error("expected an error for an unsupported result type")
+	}
+}
+
+func TestWriteResultUnsupportedFormat(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeResult(&buf, "xml", PortfolioSnapshot{}); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+}
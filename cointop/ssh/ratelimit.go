@@ -0,0 +1,111 @@
+//+build !windows
+
+package ssh
+
+import (
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"golang.org/x/time/rate"
+)
+
+// withLimits wraps handler with the server's connection-rate and
+// concurrency limits, rejecting excess connections before they can
+// spawn a PTY-backed subprocess.
+func (s *Server) withLimits(handler ssh.Handler) ssh.Handler {
+	return func(sshSession ssh.Session) {
+		remoteIP := hostOf(sshSession.RemoteAddr())
+
+		if reason, ok := s.checkLimits(remoteIP); !ok {
+			log.Printf("ssh: rejected connection from %s: %s", remoteIP, reason)
+			io.WriteString(sshSession, "Error: "+reason+"\n")
+			sshSession.Exit(1)
+			return
+		}
+
+		s.acquireSlot(remoteIP)
+		defer s.releaseSlot(remoteIP)
+
+		handler(sshSession)
+	}
+}
+
+// checkLimits reports whether a new connection from remoteIP should be
+// admitted, and if not, why.
+func (s *Server) checkLimits(remoteIP string) (reason string, ok bool) {
+	if s.connectionRateLimit != nil && !s.limiterFor(remoteIP).Allow() {
+		return "connection rate limit exceeded, try again later", false
+	}
+
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	if s.maxConcurrentSessions > 0 && len(s.activeSessions) >= s.maxConcurrentSessions {
+		return "server is at capacity, try again later", false
+	}
+
+	if s.maxSessionsPerIP > 0 && s.sessionsPerIP[remoteIP] >= s.maxSessionsPerIP {
+		return "too many concurrent sessions from your address", false
+	}
+
+	return "", true
+}
+
+// acquireSlot records a new session from remoteIP against the per-IP
+// concurrency count.
+func (s *Server) acquireSlot(remoteIP string) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	s.sessionsPerIP[remoteIP]++
+}
+
+// releaseSlot releases a session's per-IP concurrency slot.
+func (s *Server) releaseSlot(remoteIP string) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	s.sessionsPerIP[remoteIP]--
+	if s.sessionsPerIP[remoteIP] <= 0 {
+		delete(s.sessionsPerIP, remoteIP)
+	}
+}
+
+// limiterFor returns (creating if necessary) the token-bucket rate
+// limiter for remoteIP.
+func (s *Server) limiterFor(remoteIP string) *rate.Limiter {
+	s.limitersMu.Lock()
+	defer s.limitersMu.Unlock()
+
+	limiter, ok := s.limitersPerIP[remoteIP]
+	if !ok {
+		count := s.connectionRateLimit.Count
+		if count <= 0 {
+			count = 1
+		}
+
+		every := rate.Every(s.connectionRateLimit.Per / time.Duration(count))
+		limiter = rate.NewLimiter(every, count)
+		s.limitersPerIP[remoteIP] = limiter
+	}
+
+	return limiter
+}
+
+// hostOf extracts the host portion of a net.Addr, falling back to its
+// full string form if it isn't a "host:port" address.
+func hostOf(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return strings.TrimSpace(addr.String())
+	}
+
+	return host
+}
@@ -0,0 +1,77 @@
+//+build !windows
+
+package ssh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// fakeSession is a minimal ssh.Session usable as a distinct, comparable
+// map key, for exercising the activeSessions-based limits without a
+// real SSH connection.
+type fakeSession struct {
+	ssh.Session
+}
+
+func TestCheckLimitsMaxConcurrentSessions(t *testing.T) {
+	s := NewServer(&Config{MaxConcurrentSessions: 1})
+
+	s.registerSession(&fakeSession{})
+	if _, ok := s.checkLimits("10.0.0.2"); ok {
+		t.Error("expected a second connection to be rejected once MaxConcurrentSessions is reached")
+	}
+
+	for sess := range s.activeSessions {
+		s.unregisterSession(sess)
+	}
+
+	if _, ok := s.checkLimits("10.0.0.2"); !ok {
+		t.Error("expected a connection to be admitted once a slot frees up")
+	}
+}
+
+func TestCheckLimitsMaxSessionsPerIP(t *testing.T) {
+	s := NewServer(&Config{MaxSessionsPerIP: 1})
+
+	s.acquireSlot("10.0.0.1")
+	if _, ok := s.checkLimits("10.0.0.1"); ok {
+		t.Error("expected a second session from the same IP to be rejected")
+	}
+
+	if _, ok := s.checkLimits("10.0.0.2"); !ok {
+		t.Error("expected a different IP to be unaffected by another IP's cap")
+	}
+}
+
+func TestLimiterForZeroCountDoesNotPanic(t *testing.T) {
+	s := NewServer(&Config{ConnectionRateLimit: &RateLimit{Count: 0, Per: time.Minute}})
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("limiterFor panicked with zero Count: %v", r)
+		}
+	}()
+
+	if reason, ok := s.checkLimits("10.0.0.1"); !ok {
+		t.Errorf("expected a misconfigured (Count: 0) rate limit to be treated as unconfigured, got rejection: %s", reason)
+	}
+}
+
+func TestLimiterForAppliesBurst(t *testing.T) {
+	s := NewServer(&Config{ConnectionRateLimit: &RateLimit{Count: 2, Per: time.Minute}})
+
+	if _, ok := s.checkLimits("10.0.0.1"); !ok {
+		t.Error("expected first connection within burst to be admitted")
+	}
+
+	if _, ok := s.checkLimits("10.0.0.1"); !ok {
+		t.Error("expected second connection within burst to be admitted")
+	}
+
+	if _, ok := s.checkLimits("10.0.0.1"); ok {
+		t.Error("expected third connection to exceed the burst and be rejected")
+	}
+}
@@ -0,0 +1,130 @@
+//+build !windows
+
+package ssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// asciicastHeader is the first line of an asciicast v2 recording.
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env"`
+}
+
+// sessionRecorder tees a PTY session's output (and optionally input) to
+// an asciicast v2 file.
+type sessionRecorder struct {
+	mu    sync.Mutex
+	f     *os.File
+	enc   *json.Encoder
+	start time.Time
+}
+
+// newSessionRecorder creates the recording file
+// <dir>/<timestamp>-<user>-<fingerprint>.cast and writes its asciicast
+// v2 header.
+func newSessionRecorder(dir, user, fingerprint, term string, width, height int) (*sessionRecorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%d-%s-%s.cast", now.Unix(), sanitizeFilenamePart(user), sanitizeFilenamePart(fingerprint))
+
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &sessionRecorder{f: f, enc: json.NewEncoder(f), start: now}
+
+	header := asciicastHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: now.Unix(),
+		Env: map[string]string{
+			"TERM":  term,
+			"SHELL": os.Getenv("SHELL"),
+		},
+	}
+
+	if err := rec.enc.Encode(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// writeEvent appends one asciicast v2 event line and flushes it.
+func (r *sessionRecorder) writeEvent(kind string, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elapsed := time.Since(r.start).Seconds()
+	if err := r.enc.Encode([]interface{}{elapsed, kind, string(data)}); err != nil {
+		return err
+	}
+
+	return r.f.Sync()
+}
+
+// Close closes the underlying recording file.
+func (r *sessionRecorder) Close() error {
+	return r.f.Close()
+}
+
+// recorderWriter adapts a sessionRecorder event kind ("o" or "i") to an
+// io.Writer, so it can be teed alongside the real PTY/session streams.
+type recorderWriter struct {
+	rec  *sessionRecorder
+	kind string
+}
+
+// Write implements io.Writer.
+func (w recorderWriter) Write(p []byte) (int, error) {
+	if err := w.rec.writeEvent(w.kind, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// sanitizeFilenamePart replaces path separators and ':' with '_' (and
+// an empty string with "unknown") so it's safe to use as part of a
+// recording filename.
+func sanitizeFilenamePart(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+
+	replacer := func(r rune) rune {
+		switch r {
+		case '/', '\\', ':':
+			return '_'
+		default:
+			return r
+		}
+	}
+
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		out = append(out, replacer(r))
+	}
+
+	return string(out)
+}
+
+// ensure io.Writer is satisfied at compile time.
+var _ io.Writer = recorderWriter{}
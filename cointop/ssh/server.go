@@ -12,42 +12,179 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 	"unsafe"
 
+	"github.com/BurntSushi/toml"
 	"github.com/creack/pty"
 	"github.com/gliderlabs/ssh"
 	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/time/rate"
 )
 
+// contextKey is the type used for values stored on an ssh.Context.
+type contextKey string
+
+// fingerprintContextKey is the ssh.Context key holding the SHA256 fingerprint
+// of the client's authenticated public key, set by PublicKeyHandler.
+const fingerprintContextKey contextKey = "public-key-fingerprint"
+
+// fingerprintOf returns the stable SHA256 fingerprint for key. For a
+// certificate, that's the fingerprint of the signed leaf key, not the
+// certificate itself: a CA reissuing the same leaf key under a new
+// certificate (fresh serial/nonce, renewed validity window) must not
+// change the user's persistent config path.
+func fingerprintOf(key ssh.PublicKey) string {
+	if cert, ok := key.(*gossh.Certificate); ok {
+		return gossh.FingerprintSHA256(cert.Key)
+	}
+
+	return gossh.FingerprintSHA256(key)
+}
+
 // Config ...
 type Config struct {
-	Port             uint
-	Address          string
-	IdleTimeout      time.Duration
+	Port        uint
+	Address     string
+	IdleTimeout time.Duration
+	// ExecutableBinary is the cointop binary forked per session when UI
+	// is nil. It's a fallback for deployments that need per-session
+	// process isolation.
 	ExecutableBinary string
+	// UI, when set, runs the cointop UI in-process rather than forking
+	// ExecutableBinary per session.
+	UI CointopUI
+	// UserConfigDir is the base directory under which per-user config
+	// files are persisted, keyed by SSH public key fingerprint. If empty,
+	// sessions fall back to a scratch temp config that is discarded on
+	// exit.
+	UserConfigDir string
+	// AuthorizedFingerprints, if non-empty, restricts persistent config
+	// slots to clients whose public key fingerprint (as produced by
+	// golang.org/x/crypto/ssh.FingerprintSHA256) appears in the list.
+	// Clients not in the list still get a session, but with a scratch
+	// config that is not persisted. An empty list allows any client.
+	AuthorizedFingerprints []string
+	// AuthorizedKeysFile is the path to an OpenSSH authorized_keys file.
+	// Clients presenting a public key matching one of its entries are
+	// authenticated.
+	AuthorizedKeysFile string
+	// TrustedUserCAKeys is the path to an authorized_keys-format file
+	// listing CA public keys. Clients presenting a certificate signed by
+	// one of these CAs, valid for the requested login user, are
+	// authenticated.
+	TrustedUserCAKeys string
+	// PasswordFile is the path to a file of "username:bcrypt-hash" lines
+	// used to authenticate password logins.
+	PasswordFile string
+	// AllowAnonymous opts in to accepting any client when none of
+	// AuthorizedKeysFile, TrustedUserCAKeys, or PasswordFile are
+	// configured. Without it, a server with no auth mode configured
+	// rejects every connection.
+	AllowAnonymous bool
+	// DataSource backs the non-PTY "exec" subsystem (e.g.
+	// `ssh host cointop prices BTC,ETH --format=json`). If nil, exec
+	// requests are rejected.
+	DataSource CoinDataSource
+	// MaxTimeout is the absolute maximum lifetime of a connection,
+	// regardless of activity. Unlike IdleTimeout, it also bounds
+	// long-running but active TUI sessions. Zero means unbounded.
+	MaxTimeout time.Duration
+	// MaxConcurrentSessions caps the number of sessions open across the
+	// whole server at once. Zero means unbounded.
+	MaxConcurrentSessions int
+	// MaxSessionsPerIP caps the number of concurrent sessions from a
+	// single source IP. Zero means unbounded.
+	MaxSessionsPerIP int
+	// ConnectionRateLimit, if set, caps how often a single source IP may
+	// open new connections.
+	ConnectionRateLimit *RateLimit
+	// SessionLogDir, if set, enables asciicast v2 session recording:
+	// each session's output (and, if RecordInput is true, input) is
+	// written to <SessionLogDir>/<timestamp>-<user>-<fingerprint>.cast.
+	SessionLogDir string
+	// RecordInput additionally records session input events. Only takes
+	// effect when SessionLogDir is set.
+	RecordInput bool
+}
+
+// RateLimit describes a token-bucket limit of Count connections per Per
+// duration, with bursts up to Count allowed.
+type RateLimit struct {
+	Count int
+	Per   time.Duration
 }
 
 // Server ...
 type Server struct {
-	port             uint
-	address          string
-	idleTimeout      time.Duration
-	executableBinary string
-	sshServer        *ssh.Server
+	port                   uint
+	address                string
+	idleTimeout            time.Duration
+	executableBinary       string
+	ui                     CointopUI
+	userConfigDir          string
+	authorizedFingerprints []string
+	authorizedKeysFile     string
+	trustedUserCAKeys      string
+	passwordFile           string
+	allowAnonymous         bool
+	dataSource             CoinDataSource
+	maxTimeout             time.Duration
+	maxConcurrentSessions  int
+	maxSessionsPerIP       int
+	connectionRateLimit    *RateLimit
+	sessionsMu             sync.Mutex
+	activeSessions         map[ssh.Session]struct{}
+	sessionsPerIP          map[string]int
+	limitersMu             sync.Mutex
+	limitersPerIP          map[string]*rate.Limiter
+	sessionLogDir          string
+	recordInput            bool
+	sshServer              *ssh.Server
 }
 
 // NewServer ...
 func NewServer(config *Config) *Server {
+	rateLimit := config.ConnectionRateLimit
+	if rateLimit != nil && rateLimit.Count <= 0 {
+		// A rate limit with no burst size can't be turned into a token
+		// bucket; treat it as unconfigured rather than dividing by zero.
+		rateLimit = nil
+	}
+
 	return &Server{
-		port:             config.Port,
-		address:          config.Address,
-		idleTimeout:      config.IdleTimeout,
-		executableBinary: config.ExecutableBinary,
+		port:                   config.Port,
+		address:                config.Address,
+		idleTimeout:            config.IdleTimeout,
+		executableBinary:       config.ExecutableBinary,
+		ui:                     config.UI,
+		userConfigDir:          config.UserConfigDir,
+		authorizedFingerprints: config.AuthorizedFingerprints,
+		authorizedKeysFile:     config.AuthorizedKeysFile,
+		trustedUserCAKeys:      config.TrustedUserCAKeys,
+		passwordFile:           config.PasswordFile,
+		allowAnonymous:         config.AllowAnonymous,
+		dataSource:             config.DataSource,
+		maxTimeout:             config.MaxTimeout,
+		maxConcurrentSessions:  config.MaxConcurrentSessions,
+		maxSessionsPerIP:       config.MaxSessionsPerIP,
+		connectionRateLimit:    rateLimit,
+		activeSessions:         make(map[ssh.Session]struct{}),
+		sessionsPerIP:          make(map[string]int),
+		limitersPerIP:          make(map[string]*rate.Limiter),
+		sessionLogDir:          config.SessionLogDir,
+		recordInput:            config.RecordInput,
 	}
 }
 
+// authModeConfigured reports whether any real authentication mode has
+// been configured.
+func (s *Server) authModeConfigured() bool {
+	return s.authorizedKeysFile != "" || s.trustedUserCAKeys != "" || s.passwordFile != ""
+}
+
 // ListenAndServe ...
 func (s *Server) ListenAndServe() error {
 	homeDir, err := os.UserHomeDir()
@@ -58,60 +195,40 @@ func (s *Server) ListenAndServe() error {
 	s.sshServer = &ssh.Server{
 		Addr:        fmt.Sprintf("%s:%v", s.address, s.port),
 		IdleTimeout: s.idleTimeout,
-		Handler: func(sshSession ssh.Session) {
-			ptyReq, winCh, isPty := sshSession.Pty()
-			if !isPty {
-				io.WriteString(sshSession, "Error: Non-interactive terminals are not supported")
-				sshSession.Exit(1)
-				return
-			}
-
-			configPath, err := createTempConfig()
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-
-			cmdCtx, cancelCmd := context.WithCancel(sshSession.Context())
-			defer cancelCmd()
-
-			cmd := exec.CommandContext(cmdCtx, s.executableBinary, "--config", configPath)
-			cmd.Env = append(sshSession.Environ(), fmt.Sprintf("TERM=%s", ptyReq.Term))
-
-			f, err := pty.Start(cmd)
-			if err != nil {
-				io.WriteString(sshSession, err.Error())
-			}
-
-			defer f.Close()
-
-			go func() {
-				for win := range winCh {
-					setWinsize(f, win.Width, win.Height)
-				}
-			}()
-
-			go func() {
-				io.Copy(f, sshSession)
-			}()
-
-			io.Copy(sshSession, f)
-			f.Close()
-			cmd.Wait()
-			os.Remove(configPath)
-		},
+		MaxTimeout:  s.maxTimeout,
+		Handler:     s.withLimits(s.handleSession),
 		PtyCallback: func(ctx ssh.Context, pty ssh.Pty) bool {
-			// TODO: check public key hash
+			// Auth already happened in PublicKeyHandler/PasswordHandler;
+			// every session reaching here has been admitted.
 			return true
 		},
 		PublicKeyHandler: func(ctx ssh.Context, key ssh.PublicKey) bool {
-			return true
+			ctx.SetValue(fingerprintContextKey, fingerprintOf(key))
+
+			if !s.authModeConfigured() {
+				return s.allowAnonymous
+			}
+
+			return s.checkCertificate(ctx, key) || s.checkAuthorizedKey(key)
 		},
 		PasswordHandler: func(ctx ssh.Context, password string) bool {
-			return true
+			if !s.authModeConfigured() {
+				return s.allowAnonymous
+			}
+
+			return s.checkPassword(ctx.User(), password)
 		},
 		KeyboardInteractiveHandler: func(ctx ssh.Context, challenger gossh.KeyboardInteractiveChallenge) bool {
-			return true
+			if s.authModeConfigured() {
+				// Keyboard-interactive isn't one of the supported real
+				// auth modes; fall through to key/password auth.
+				return false
+			}
+
+			return s.allowAnonymous
+		},
+		SubsystemHandlers: map[string]ssh.SubsystemHandler{
+			"sftp": ssh.SubsystemHandler(s.withLimits(s.sftpSubsystemHandler)),
 		},
 	}
 
@@ -128,9 +245,102 @@ func (s *Server) ListenAndServe() error {
 	return s.sshServer.ListenAndServe()
 }
 
-// Shutdown ...
-func (s *Server) Shutdown() {
-	s.sshServer.Close()
+// handleSession runs an interactive PTY session, or dispatches to the
+// exec subsystem for non-PTY requests.
+func (s *Server) handleSession(sshSession ssh.Session) {
+	s.registerSession(sshSession)
+	defer s.unregisterSession(sshSession)
+
+	ptyReq, winCh, isPty := sshSession.Pty()
+	if !isPty {
+		s.handleExec(sshSession)
+		return
+	}
+
+	fingerprint, _ := sshSession.Context().Value(fingerprintContextKey).(string)
+	persistent := s.persistenceAllowed(fingerprint)
+
+	configPath, err := s.resolveConfig(fingerprint, persistent)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	output, input, closeRecording := s.wrapRecording(sshSession, fingerprint, ptyReq, winCh)
+	defer closeRecording()
+
+	if s.ui != nil {
+		s.attachUI(sshSession, ptyReq, winCh, output, input, configPath)
+	} else {
+		s.runSubprocess(sshSession, ptyReq, winCh, output, input, configPath)
+	}
+
+	if persistent {
+		if err := s.savePersistedConfig(fingerprint, configPath); err != nil {
+			fmt.Println(err)
+		}
+	} else {
+		os.Remove(configPath)
+	}
+}
+
+// runSubprocess runs the cointop UI as a forked subprocess attached to
+// a PTY, the fallback used when Config.UI isn't set.
+func (s *Server) runSubprocess(sshSession ssh.Session, ptyReq ssh.Pty, winCh <-chan ssh.Window, output io.Writer, input io.Reader, configPath string) {
+	cmdCtx, cancelCmd := context.WithCancel(sshSession.Context())
+	defer cancelCmd()
+
+	cmd := exec.CommandContext(cmdCtx, s.executableBinary, "--config", configPath)
+	cmd.Env = append(sshSession.Environ(), fmt.Sprintf("TERM=%s", ptyReq.Term))
+
+	f, err := pty.Start(cmd)
+	if err != nil {
+		io.WriteString(sshSession, err.Error())
+		return
+	}
+
+	defer f.Close()
+
+	go func() {
+		for win := range winCh {
+			setWinsize(f, win.Width, win.Height)
+		}
+	}()
+
+	go func() {
+		io.Copy(f, input)
+	}()
+
+	io.Copy(output, f)
+	f.Close()
+	cmd.Wait()
+}
+
+// wrapRecording tees sshSession's output (and, if enabled, input)
+// through an asciicast recorder when Config.SessionLogDir is set,
+// returning the streams the UI or subprocess path should use and a
+// cleanup func to run when the session ends.
+func (s *Server) wrapRecording(sshSession ssh.Session, fingerprint string, ptyReq ssh.Pty, winCh <-chan ssh.Window) (output io.Writer, input io.Reader, closeFn func()) {
+	output = sshSession
+	input = sshSession
+	closeFn = func() {}
+
+	if s.sessionLogDir == "" {
+		return output, input, closeFn
+	}
+
+	rec, err := newSessionRecorder(s.sessionLogDir, sshSession.User(), fingerprint, ptyReq.Term, ptyReq.Window.Width, ptyReq.Window.Height)
+	if err != nil {
+		fmt.Println(err)
+		return output, input, closeFn
+	}
+
+	output = io.MultiWriter(sshSession, recorderWriter{rec: rec, kind: "o"})
+	if s.recordInput {
+		input = io.TeeReader(sshSession, recorderWriter{rec: rec, kind: "i"})
+	}
+
+	return output, input, func() { rec.Close() }
 }
 
 // setWinsize ...
@@ -139,8 +349,91 @@ func setWinsize(f *os.File, w, h int) {
 		uintptr(unsafe.Pointer(&struct{ h, w, x, y uint16 }{uint16(h), uint16(w), 0, 0})))
 }
 
-// createTempConfig ...
-// TODO: load saved configuration based on ssh public key hash
+// persistenceAllowed reports whether fingerprint is eligible for a
+// persistent per-user config slot, based on s.userConfigDir being
+// configured and, if set, s.authorizedFingerprints permitting it.
+func (s *Server) persistenceAllowed(fingerprint string) bool {
+	if s.userConfigDir == "" || fingerprint == "" {
+		return false
+	}
+
+	if len(s.authorizedFingerprints) == 0 {
+		return true
+	}
+
+	for _, allowed := range s.authorizedFingerprints {
+		if allowed == fingerprint {
+			return true
+		}
+	}
+
+	return false
+}
+
+// userConfigPath returns the path to the persisted config.toml for the
+// given fingerprint, under s.userConfigDir.
+func (s *Server) userConfigPath(fingerprint string) string {
+	return filepath.Join(s.userConfigDir, fingerprint, "config.toml")
+}
+
+// resolveConfig returns the config file path a session should be launched
+// with. When persistent is true, it ensures the user's stable
+// per-fingerprint config exists (creating it from the default template
+// on first login) and returns a scratch copy of it. Otherwise it returns
+// an empty scratch temp config.
+func (s *Server) resolveConfig(fingerprint string, persistent bool) (string, error) {
+	if !persistent {
+		return createTempConfig()
+	}
+
+	userPath := s.userConfigPath(fingerprint)
+	if _, err := os.Stat(userPath); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(userPath), 0700); err != nil {
+			return "", err
+		}
+
+		if err := createDefaultConfig(userPath); err != nil {
+			return "", err
+		}
+	}
+
+	// The session is handed a scratch copy so a crash mid-session can't
+	// corrupt the user's saved config; savePersistedConfig copies it back
+	// on clean exit.
+	tempPath, err := createTempConfig()
+	if err != nil {
+		return "", err
+	}
+
+	if err := copyFile(userPath, tempPath); err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
+
+	return tempPath, nil
+}
+
+// savePersistedConfig copies the session's (possibly modified) scratch
+// config back to the user's persistent slot, then removes the scratch
+// file, so returning users get their portfolio, favorites, and layout
+// preserved across sessions.
+func (s *Server) savePersistedConfig(fingerprint, configPath string) error {
+	defer os.Remove(configPath)
+	return copyFile(configPath, s.userConfigPath(fingerprint))
+}
+
+// copyFile copies src to dst, overwriting dst if it exists.
+func copyFile(src, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(dst, data, 0600)
+}
+
+// createTempConfig creates a scratch config file for sessions that are
+// not eligible for (or haven't requested) a persistent config slot.
 func createTempConfig() (string, error) {
 	f, err := ioutil.TempFile("", "config")
 	if err != nil {
@@ -149,4 +442,30 @@ func createTempConfig() (string, error) {
 
 	f.Close()
 	return filepath.Clean(f.Name()), nil
-}
\ No newline at end of file
+}
+
+// validateConfig reports whether the file at path parses as valid TOML,
+// used to reject malformed config uploads before they replace a user's
+// persisted config.
+func validateConfig(path string) error {
+	var parsed map[string]interface{}
+	_, err := toml.DecodeFile(path, &parsed)
+	return err
+}
+
+// defaultConfigTemplate is the config.toml a new persistent per-user
+// slot starts from, matching the defaults cointop itself writes out on
+// first run.
+const defaultConfigTemplate = `currency = "USD"
+default_view = "table"
+favorites = []
+holdings = []
+colorscheme = "cointop"
+refresh_rate = 60
+`
+
+// createDefaultConfig writes the default config template to path, used
+// the first time a persistent per-fingerprint config slot is created.
+func createDefaultConfig(path string) error {
+	return ioutil.WriteFile(path, []byte(defaultConfigTemplate), 0600)
+}
@@ -0,0 +1,102 @@
+//+build !windows
+
+package ssh
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPersistenceAllowed(t *testing.T) {
+	cases := []struct {
+		name          string
+		userConfigDir string
+		allowed       []string
+		fingerprint   string
+		want          bool
+	}{
+		{"no config dir configured", "", nil, "fp1", false},
+		{"no fingerprint", "/tmp", nil, "", false},
+		{"empty allowlist permits anyone", "/tmp", nil, "fp1", true},
+		{"fingerprint in allowlist", "/tmp", []string{"fp1", "fp2"}, "fp1", true},
+		{"fingerprint not in allowlist", "/tmp", []string{"fp2"}, "fp1", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewServer(&Config{UserConfigDir: tc.userConfigDir, AuthorizedFingerprints: tc.allowed})
+			if got := s.persistenceAllowed(tc.fingerprint); got != tc.want {
+				t.Errorf("persistenceAllowed(%q) = %v, want %v", tc.fingerprint, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveConfigNotPersistent(t *testing.T) {
+	s := NewServer(&Config{})
+
+	path, err := s.resolveConfig("fp1", false)
+	if err != nil {
+		t.Fatalf("resolveConfig: %v", err)
+	}
+	defer os.Remove(path)
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected a scratch temp config at %s: %v", path, err)
+	}
+}
+
+func TestResolveConfigPersistentFirstLogin(t *testing.T) {
+	dir := t.TempDir()
+	s := NewServer(&Config{UserConfigDir: dir})
+
+	tempPath, err := s.resolveConfig("fp1", true)
+	if err != nil {
+		t.Fatalf("resolveConfig: %v", err)
+	}
+	defer os.Remove(tempPath)
+
+	got, err := os.ReadFile(tempPath)
+	if err != nil {
+		t.Fatalf("ReadFile(%s): %v", tempPath, err)
+	}
+
+	if string(got) != defaultConfigTemplate {
+		t.Errorf("first login config = %q, want the default template %q", got, defaultConfigTemplate)
+	}
+
+	userPath := s.userConfigPath("fp1")
+	if _, err := os.Stat(userPath); err != nil {
+		t.Errorf("expected a persisted config at %s: %v", userPath, err)
+	}
+}
+
+func TestResolveConfigPersistentReusesExisting(t *testing.T) {
+	dir := t.TempDir()
+	s := NewServer(&Config{UserConfigDir: dir})
+
+	if _, err := s.resolveConfig("fp1", true); err != nil {
+		t.Fatalf("resolveConfig (first login): %v", err)
+	}
+
+	userPath := s.userConfigPath("fp1")
+	const customConfig = "currency = \"EUR\"\n"
+	if err := os.WriteFile(userPath, []byte(customConfig), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	tempPath, err := s.resolveConfig("fp1", true)
+	if err != nil {
+		t.Fatalf("resolveConfig (second login): %v", err)
+	}
+	defer os.Remove(tempPath)
+
+	got, err := os.ReadFile(tempPath)
+	if err != nil {
+		t.Fatalf("ReadFile(%s): %v", tempPath, err)
+	}
+
+	if string(got) != customConfig {
+		t.Errorf("second login should reuse the existing persisted config, got %q, want %q", got, customConfig)
+	}
+}
@@ -0,0 +1,284 @@
+//+build !windows
+
+package ssh
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/pkg/sftp"
+)
+
+// sftpFileNames are the virtual files exposed to an authenticated user
+// over the sftp subsystem. portfolio.csv, portfolio.json, and
+// holdings.json are generated on-the-fly from that user's data;
+// config.toml is backed by the user's persisted config and may also be
+// uploaded to replace it.
+var sftpFileNames = []string{"portfolio.csv", "portfolio.json", "holdings.json", "config.toml"}
+
+// sftpSubsystemHandler serves a small per-user virtual filesystem over
+// sftp, so users can pull their data with scp/sftp without a TUI
+// session, and automation can push config updates.
+func (s *Server) sftpSubsystemHandler(sshSession ssh.Session) {
+	s.registerSession(sshSession)
+	defer s.unregisterSession(sshSession)
+
+	fingerprint, _ := sshSession.Context().Value(fingerprintContextKey).(string)
+
+	handlers := sftp.Handlers{
+		FileGet:  &sftpHandler{server: s, fingerprint: fingerprint},
+		FilePut:  &sftpHandler{server: s, fingerprint: fingerprint},
+		FileCmd:  &sftpHandler{server: s, fingerprint: fingerprint},
+		FileList: &sftpHandler{server: s, fingerprint: fingerprint},
+	}
+
+	srv := sftp.NewRequestServer(sshSession, handlers)
+	defer srv.Close()
+
+	if err := srv.Serve(); err != nil && err != io.EOF {
+		fmt.Fprintln(os.Stderr, "sftp server error:", err)
+	}
+}
+
+// sftpHandler implements the sftp.Handlers interfaces, dispatching
+// reads and writes against the virtual per-fingerprint filesystem.
+type sftpHandler struct {
+	server      *Server
+	fingerprint string
+}
+
+// Fileread implements sftp.FileReader.
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	name := trimSlash(r.Filepath)
+
+	data, err := h.render(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+// Filewrite implements sftp.FileWriter. Only config.toml may be
+// uploaded; it's buffered and atomically swapped into place once the
+// client closes the file.
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if trimSlash(r.Filepath) != "config.toml" {
+		return nil, os.ErrPermission
+	}
+
+	if !h.server.persistenceAllowed(h.fingerprint) {
+		return nil, os.ErrPermission
+	}
+
+	return &configWriter{path: h.server.userConfigPath(h.fingerprint)}, nil
+}
+
+// Filecmd implements sftp.FileCmder. The virtual filesystem is flat and
+// fixed, so remove/rename/mkdir are all rejected.
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	return os.ErrPermission
+}
+
+// Filelist implements sftp.FileLister.
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		if trimSlash(r.Filepath) != "" {
+			return nil, os.ErrNotExist
+		}
+
+		infos := make([]os.FileInfo, 0, len(sftpFileNames))
+		for _, name := range sftpFileNames {
+			infos = append(infos, virtualFileInfo{name: name})
+		}
+
+		return listerAt(infos), nil
+	case "Stat":
+		name := trimSlash(r.Filepath)
+		if name == "" {
+			return listerAt([]os.FileInfo{virtualFileInfo{name: "/", dir: true}}), nil
+		}
+
+		for _, known := range sftpFileNames {
+			if known == name {
+				return listerAt([]os.FileInfo{virtualFileInfo{name: name}}), nil
+			}
+		}
+
+		return nil, os.ErrNotExist
+	default:
+		return nil, fmt.Errorf("unsupported list method %q", r.Method)
+	}
+}
+
+// render generates the current content of the named virtual file for
+// this user.
+func (h *sftpHandler) render(name string) ([]byte, error) {
+	s := h.server
+
+	switch name {
+	case "config.toml":
+		if !s.persistenceAllowed(h.fingerprint) {
+			return nil, os.ErrNotExist
+		}
+
+		return ioutil.ReadFile(s.userConfigPath(h.fingerprint))
+	case "portfolio.json":
+		if s.dataSource == nil {
+			return nil, os.ErrNotExist
+		}
+
+		if h.fingerprint == "" {
+			return nil, fmt.Errorf("portfolio data requires public-key authentication")
+		}
+
+		portfolio, err := s.dataSource.Portfolio(h.fingerprint)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(portfolio)
+	case "portfolio.csv":
+		if s.dataSource == nil {
+			return nil, os.ErrNotExist
+		}
+
+		if h.fingerprint == "" {
+			return nil, fmt.Errorf("portfolio data requires public-key authentication")
+		}
+
+		portfolio, err := s.dataSource.Portfolio(h.fingerprint)
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		cw := csv.NewWriter(&buf)
+		cw.Write([]string{"symbol", "quantity", "value"})
+		for _, holding := range portfolio.Holdings {
+			cw.Write([]string{
+				holding.Symbol,
+				strconv.FormatFloat(holding.Quantity, 'f', -1, 64),
+				strconv.FormatFloat(holding.Value, 'f', -1, 64),
+			})
+		}
+		cw.Flush()
+
+		return buf.Bytes(), cw.Error()
+	case "holdings.json":
+		if s.dataSource == nil {
+			return nil, os.ErrNotExist
+		}
+
+		if h.fingerprint == "" {
+			return nil, fmt.Errorf("holdings data requires public-key authentication")
+		}
+
+		holdings, err := s.dataSource.Holdings(h.fingerprint)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(holdings)
+	default:
+		return nil, os.ErrNotExist
+	}
+}
+
+// configWriter buffers an uploaded config.toml to a temp file and
+// atomically replaces the user's persisted config on Close, after
+// validating that it parses.
+type configWriter struct {
+	path string
+	tmp  *os.File
+}
+
+// WriteAt implements io.WriterAt.
+func (w *configWriter) WriteAt(p []byte, off int64) (int, error) {
+	if w.tmp == nil {
+		// Written alongside w.path, not the OS temp dir, so the final
+		// os.Rename in Close is guaranteed to stay on one filesystem.
+		tmp, err := ioutil.TempFile(filepath.Dir(w.path), "config-upload")
+		if err != nil {
+			return 0, err
+		}
+
+		w.tmp = tmp
+	}
+
+	return w.tmp.WriteAt(p, off)
+}
+
+// Close validates the uploaded config and, if valid, atomically
+// replaces the user's persisted config with it.
+func (w *configWriter) Close() error {
+	if w.tmp == nil {
+		return nil
+	}
+
+	defer os.Remove(w.tmp.Name())
+	defer w.tmp.Close()
+
+	if err := validateConfig(w.tmp.Name()); err != nil {
+		return err
+	}
+
+	return os.Rename(w.tmp.Name(), w.path)
+}
+
+// listerAt adapts a []os.FileInfo to sftp.ListerAt.
+type listerAt []os.FileInfo
+
+// ListAt implements sftp.ListerAt.
+func (l listerAt) ListAt(infos []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+
+	n := copy(infos, l[offset:])
+	if n < len(infos) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// virtualFileInfo implements os.FileInfo for a virtual, generated file.
+type virtualFileInfo struct {
+	name string
+	dir  bool
+}
+
+func (i virtualFileInfo) Name() string { return i.name }
+func (i virtualFileInfo) Size() int64  { return 0 }
+
+func (i virtualFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0500
+	}
+
+	return 0400
+}
+
+func (i virtualFileInfo) ModTime() time.Time { return time.Now() }
+func (i virtualFileInfo) IsDir() bool        { return i.dir }
+func (i virtualFileInfo) Sys() interface{}   { return nil }
+
+// trimSlash strips the sftp client's leading "/" from a virtual path.
+func trimSlash(p string) string {
+	if len(p) > 0 && p[0] == '/' {
+		return p[1:]
+	}
+
+	return p
+}
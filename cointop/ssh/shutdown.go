@@ -0,0 +1,62 @@
+//+build !windows
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// registerSession tracks sshSession as active, so it counts toward
+// MaxConcurrentSessions and can be warned during Shutdown.
+func (s *Server) registerSession(sshSession ssh.Session) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	s.activeSessions[sshSession] = struct{}{}
+}
+
+// unregisterSession stops tracking sshSession, called once it ends.
+func (s *Server) unregisterSession(sshSession ssh.Session) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	delete(s.activeSessions, sshSession)
+}
+
+// broadcast writes msg to every currently active session's PTY.
+func (s *Server) broadcast(msg string) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+
+	for sshSession := range s.activeSessions {
+		// Exec and sftp sessions carry machine-readable streams; only
+		// interactive PTY sessions get the text warning.
+		if _, _, isPty := sshSession.Pty(); !isPty {
+			continue
+		}
+
+		io.WriteString(sshSession, msg)
+	}
+}
+
+// Shutdown gracefully stops the server: it stops accepting new
+// connections, warns every active PTY session, then waits for sessions to
+// end on their own or for ctx to expire, whichever comes first. If ctx
+// expires first, remaining sessions are force-closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		s.broadcast(fmt.Sprintf("\r\n*** server shutting down in %s ***\r\n", time.Until(deadline).Round(time.Second)))
+	} else {
+		s.broadcast("\r\n*** server shutting down ***\r\n")
+	}
+
+	err := s.sshServer.Shutdown(ctx)
+	if ctx.Err() != nil {
+		return s.sshServer.Close()
+	}
+
+	return err
+}
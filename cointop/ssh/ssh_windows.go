@@ -0,0 +1,226 @@
+//+build windows
+
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/UserExistsError/conpty"
+	"github.com/gliderlabs/ssh"
+	"golang.org/x/crypto/bcrypt"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Config ...
+//
+// This is the Windows counterpart of the Unix Config in ssh/server.go.
+// It covers the core PTY-over-SSH functionality and the authorized_keys
+// / password-file auth modes. The sftp and exec subsystems, rate
+// limiting, CA-certificate auth, per-user persistent configs, session
+// recording, the in-process UI, and the shutdown warning of the Unix
+// implementation are not available here.
+type Config struct {
+	Port             uint
+	Address          string
+	IdleTimeout      time.Duration
+	ExecutableBinary string
+	MaxTimeout       time.Duration
+	// AuthorizedKeysFile is the path to an OpenSSH authorized_keys file.
+	AuthorizedKeysFile string
+	// PasswordFile is the path to a file of "username:bcrypt-hash" lines.
+	PasswordFile string
+	// AllowAnonymous opts in to accepting any client when neither
+	// AuthorizedKeysFile nor PasswordFile are configured. Without it, a
+	// server with no auth mode configured rejects every connection.
+	AllowAnonymous bool
+}
+
+// Server ...
+type Server struct {
+	port               uint
+	address            string
+	idleTimeout        time.Duration
+	executableBinary   string
+	maxTimeout         time.Duration
+	authorizedKeysFile string
+	passwordFile       string
+	allowAnonymous     bool
+	sshServer          *ssh.Server
+}
+
+// NewServer ...
+func NewServer(config *Config) *Server {
+	return &Server{
+		port:               config.Port,
+		address:            config.Address,
+		idleTimeout:        config.IdleTimeout,
+		executableBinary:   config.ExecutableBinary,
+		maxTimeout:         config.MaxTimeout,
+		authorizedKeysFile: config.AuthorizedKeysFile,
+		passwordFile:       config.PasswordFile,
+		allowAnonymous:     config.AllowAnonymous,
+	}
+}
+
+// authModeConfigured reports whether any real authentication mode has
+// been configured.
+func (s *Server) authModeConfigured() bool {
+	return s.authorizedKeysFile != "" || s.passwordFile != ""
+}
+
+// ListenAndServe ...
+func (s *Server) ListenAndServe() error {
+	s.sshServer = &ssh.Server{
+		Addr:        fmt.Sprintf("%s:%v", s.address, s.port),
+		IdleTimeout: s.idleTimeout,
+		MaxTimeout:  s.maxTimeout,
+		Handler: func(sshSession ssh.Session) {
+			ptyReq, winCh, isPty := sshSession.Pty()
+			if !isPty {
+				io.WriteString(sshSession, "Error: Non-interactive terminals are not supported")
+				sshSession.Exit(1)
+				return
+			}
+
+			configPath, err := createTempConfig()
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			defer os.Remove(configPath)
+
+			cmdCtx, cancelCmd := context.WithCancel(sshSession.Context())
+			defer cancelCmd()
+
+			cmdLine := fmt.Sprintf(`"%s" --config "%s"`, s.executableBinary, configPath)
+			cpty, err := conpty.Start(cmdLine,
+				conpty.ConPtyDimensions(ptyReq.Window.Width, ptyReq.Window.Height),
+			)
+			if err != nil {
+				io.WriteString(sshSession, err.Error())
+				return
+			}
+			defer cpty.Close()
+
+			go func() {
+				for win := range winCh {
+					cpty.Resize(win.Width, win.Height)
+				}
+			}()
+
+			go func() {
+				io.Copy(cpty, sshSession)
+			}()
+
+			go func() {
+				<-cmdCtx.Done()
+				cpty.Close()
+			}()
+
+			io.Copy(sshSession, cpty)
+			cpty.Wait(cmdCtx)
+		},
+		PtyCallback: func(ctx ssh.Context, pty ssh.Pty) bool {
+			return true
+		},
+		PublicKeyHandler: func(ctx ssh.Context, key ssh.PublicKey) bool {
+			if !s.authModeConfigured() {
+				return s.allowAnonymous
+			}
+
+			return s.checkAuthorizedKey(key)
+		},
+		PasswordHandler: func(ctx ssh.Context, password string) bool {
+			if !s.authModeConfigured() {
+				return s.allowAnonymous
+			}
+
+			return s.checkPassword(ctx.User(), password)
+		},
+	}
+
+	return s.sshServer.ListenAndServe()
+}
+
+// Shutdown ...
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.sshServer.Shutdown(ctx)
+}
+
+// createTempConfig creates a scratch config file for the session.
+func createTempConfig() (string, error) {
+	f, err := ioutil.TempFile("", "config")
+	if err != nil {
+		return "", err
+	}
+
+	f.Close()
+	return filepath.Clean(f.Name()), nil
+}
+
+// checkAuthorizedKey reports whether key matches an entry in
+// s.authorizedKeysFile.
+func (s *Server) checkAuthorizedKey(key ssh.PublicKey) bool {
+	if s.authorizedKeysFile == "" {
+		return false
+	}
+
+	data, err := os.ReadFile(s.authorizedKeysFile)
+	if err != nil {
+		return false
+	}
+
+	for len(data) > 0 {
+		authorized, _, _, rest, err := gossh.ParseAuthorizedKey(data)
+		if err != nil {
+			break
+		}
+
+		if ssh.KeysEqual(key, authorized) {
+			return true
+		}
+
+		data = rest
+	}
+
+	return false
+}
+
+// checkPassword reports whether password matches the bcrypt hash stored
+// for user in s.passwordFile, a file of "username:bcrypt-hash" lines.
+func (s *Server) checkPassword(user, password string) bool {
+	if s.passwordFile == "" {
+		return false
+	}
+
+	f, err := os.Open(s.passwordFile)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] != user {
+			continue
+		}
+
+		return bcrypt.CompareHashAndPassword([]byte(parts[1]), []byte(password)) == nil
+	}
+
+	return false
+}
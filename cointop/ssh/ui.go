@@ -0,0 +1,66 @@
+//+build !windows
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/gliderlabs/ssh"
+)
+
+// CointopUI runs the cointop core/UI in-process, attached to a single
+// terminal. A single implementation is expected to be shared across
+// every concurrent SSH session.
+type CointopUI interface {
+	// Attach runs one interactive session over term, blocking until the
+	// session ends or ctx is canceled.
+	Attach(ctx context.Context, term TerminalIO) error
+}
+
+// TerminalIO is the terminal a CointopUI session is attached to.
+type TerminalIO struct {
+	Stdin      io.Reader
+	Stdout     io.Writer
+	Term       string
+	Width      int
+	Height     int
+	Resize     <-chan Window
+	ConfigPath string
+}
+
+// Window is a terminal resize event.
+type Window struct {
+	Width  int
+	Height int
+}
+
+// attachUI runs the in-process cointop UI for a session, the path used
+// instead of runSubprocess when Config.UI is set.
+func (s *Server) attachUI(sshSession ssh.Session, ptyReq ssh.Pty, winCh <-chan ssh.Window, output io.Writer, input io.Reader, configPath string) {
+	resizeCh := make(chan Window)
+	go func() {
+		defer close(resizeCh)
+		for win := range winCh {
+			resizeCh <- Window{Width: win.Width, Height: win.Height}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(sshSession.Context())
+	defer cancel()
+
+	term := TerminalIO{
+		Stdin:      input,
+		Stdout:     output,
+		Term:       ptyReq.Term,
+		Width:      ptyReq.Window.Width,
+		Height:     ptyReq.Window.Height,
+		Resize:     resizeCh,
+		ConfigPath: configPath,
+	}
+
+	if err := s.ui.Attach(ctx, term); err != nil {
+		fmt.Fprintf(output, "Error: %s\n", err)
+	}
+}